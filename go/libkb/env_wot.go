@@ -0,0 +1,34 @@
+package libkb
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetWotVouchFetchConcurrency returns the configured worker pool size for
+// FetchPendingWotVouches's expansion/standardization passes, or 0 if unset,
+// in which case callers fall back to a hardcoded default. WOT vouch fetching
+// is new enough that it has no config.json schema yet, so this reads straight
+// from the environment instead of going through ConfigReader.
+func (e *Env) GetWotVouchFetchConcurrency() int {
+	n, err := strconv.Atoi(os.Getenv("KEYBASE_WOT_VOUCH_FETCH_CONCURRENCY"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// GetWotOIDCIssuerAllowlist returns the OIDC issuers a voucher is allowed to
+// cite as the verifier behind a username_verified_via: OIDC_TOKEN vouch. An
+// empty list means no OIDC-backed vouch will validate. Like
+// GetWotVouchFetchConcurrency, this is new enough to have no config.json
+// schema yet, so it reads straight from the environment instead of going
+// through ConfigReader.
+func (e *Env) GetWotOIDCIssuerAllowlist() []string {
+	v := os.Getenv("KEYBASE_WOT_OIDC_ISSUER_ALLOWLIST")
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
@@ -7,6 +7,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -27,7 +29,13 @@ import (
 	context "golang.org/x/net/context"
 
 	jsonw "github.com/keybase/go-jsonw"
-	ps "github.com/keybase/go-ps"
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	gnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
 // Logs is the struct to specify the path of log files
@@ -51,6 +59,104 @@ type Logs struct {
 type LogSendContext struct {
 	Contextified
 	Logs Logs
+
+	// Redactor scrubs secrets and PII out of everything LogSend uploads.
+	// If nil, a default Redactor is lazily created on first use.
+	Redactor *Redactor
+}
+
+// LogSendOpts controls optional, rarely-used behavior of LogSend.
+type LogSendOpts struct {
+	// DisableRedaction turns off log scrubbing entirely, for the rare case
+	// where an operator needs to see raw logs while debugging.
+	DisableRedaction bool
+	// DryRun builds the same bundle LogSend would upload, but doesn't
+	// actually send it anywhere. It returns the SHA-256 of the bundle that
+	// would have been sent, so callers can sanity-check it.
+	DryRun bool
+}
+
+// logBundle holds everything LogSend or LogSendToFile collects from the
+// filesystem/environment for a single run, before it's either POSTed or
+// written to a local archive. post and buildBundle both read from the same
+// logBundle so the two exporters can't drift on what they include.
+type logBundle struct {
+	status, feedback                                                                        string
+	kbfsLog, svcLog, ekLog, desktopLog, updaterLog, startLog, installLog, systemLog, gitLog string
+	watchdogLog, processesLog, processesSnapshot, redactionReport                           string
+	traceBundle, cpuProfileBundle                                                           []byte
+	uid                                                                                     keybase1.UID
+	installID                                                                               InstallID
+}
+
+// collect gathers every log tail, trace/CPU-profile tarball, and system
+// snapshot that make up a LogSend bundle, scrubbing everything (except
+// opts.DisableRedaction) along the way.
+func (l *LogSendContext) collect(mctx MetaContext, statusJSON, feedback string, sendLogs bool, numBytes int, uid keybase1.UID, installID InstallID, mergeExtendedStatus bool, opts LogSendOpts) *logBundle {
+	logs := l.Logs
+	b := &logBundle{status: statusJSON, feedback: feedback, uid: uid, installID: installID}
+
+	if !sendLogs {
+		return b
+	}
+
+	var redactor *Redactor
+	if !opts.DisableRedaction {
+		redactor = l.redactor()
+		redactor.Reset()
+	}
+
+	b.svcLog = l.scrub(opts, tail(l.G().Log, "service", logs.Service, numBytes))
+	b.ekLog = l.scrub(opts, tail(l.G().Log, "ek", logs.EK, numBytes))
+	b.kbfsLog = l.scrub(opts, tail(l.G().Log, "kbfs", logs.Kbfs, numBytes))
+	b.desktopLog = l.scrub(opts, tail(l.G().Log, "desktop", logs.Desktop, numBytes))
+	b.updaterLog = l.scrub(opts, tail(l.G().Log, "updater", logs.Updater, numBytes))
+	// We don't use the systemd journal to store regular logs, since on some
+	// systems (e.g. Ubuntu 16.04) it's not persisted across boots. However
+	// we do use it for startup logs, since that's the only place to get
+	// them in systemd mode.
+	if l.G().Env.WantsSystemd() {
+		b.startLog = l.scrub(opts, tailSystemdJournal(l.G().Log, []string{"keybase.service", "keybase.ek", "kbfs.service", "keybase.gui.service", "keybase-redirector.service"}, numBytes))
+	} else {
+		b.startLog = l.scrub(opts, tail(l.G().Log, "start", logs.Start, numBytes))
+	}
+	b.installLog = l.scrub(opts, tail(l.G().Log, "install", logs.Install, numBytes))
+	b.systemLog = l.scrub(opts, tailSystemLog(l.G().Log, logs.System, numBytes))
+	b.gitLog = l.scrub(opts, tail(l.G().Log, "git", logs.Git, numBytes))
+	b.watchdogLog = l.scrub(opts, tail(l.G().Log, "watchdog", logs.Watchdog, numBytes))
+	if logs.Trace != "" {
+		b.traceBundle = getTraceBundle(l.G().Log, logs.Trace, redactor)
+	}
+	if logs.CPUProfile != "" {
+		b.cpuProfileBundle = getCPUProfileBundle(l.G().Log, logs.CPUProfile, redactor)
+	}
+	// Only add extended status if we're sending logs
+	if mergeExtendedStatus {
+		b.status = l.scrub(opts, l.mergeExtendedStatus(statusJSON))
+	}
+	processesLog, processesSnapshot := keybaseProcessList(mctx)
+	b.processesLog = l.scrub(opts, processesLog)
+	b.processesSnapshot = l.scrub(opts, processesSnapshot)
+	if redactor != nil {
+		b.redactionReport = redactor.Report()
+	}
+	return b
+}
+
+// redactor returns l.Redactor, lazily creating a default one if it's unset.
+func (l *LogSendContext) redactor() *Redactor {
+	if l.Redactor == nil {
+		l.Redactor = NewRedactor()
+	}
+	return l.Redactor
+}
+
+// scrub redacts s unless opts disables redaction.
+func (l *LogSendContext) scrub(opts LogSendOpts, s string) string {
+	if opts.DisableRedaction {
+		return s
+	}
+	return l.redactor().Scrub(s)
 }
 
 func addFile(mpart *multipart.Writer, param, filename string, data []byte) error {
@@ -82,71 +188,38 @@ func addGzippedFile(mpart *multipart.Writer, param, filename, data string) error
 	return gz.Close()
 }
 
-func (l *LogSendContext) post(mctx MetaContext, status, feedback, kbfsLog, svcLog, ekLog, desktopLog, updaterLog, startLog, installLog, systemLog, gitLog, watchdogLog string, traceBundle, cpuProfileBundle []byte, uid keybase1.UID, installID InstallID, processesLog string) (string, error) {
+func (l *LogSendContext) post(mctx MetaContext, b *logBundle) (string, error) {
 	mctx.Debug("sending status + logs to keybase")
 
 	var body bytes.Buffer
 	mpart := multipart.NewWriter(&body)
 
-	if feedback != "" {
-		mpart.WriteField("feedback", feedback)
+	if b.feedback != "" {
+		mpart.WriteField("feedback", b.feedback)
 	}
 
-	if len(installID) > 0 {
-		mpart.WriteField("install_id", string(installID))
+	if len(b.installID) > 0 {
+		mpart.WriteField("install_id", string(b.installID))
 	}
 
-	if !uid.IsNil() {
-		mpart.WriteField("uid", uid.String())
+	if !b.uid.IsNil() {
+		mpart.WriteField("uid", b.uid.String())
 	}
 
-	if err := addGzippedFile(mpart, "status_gz", "status.gz", status); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "kbfs_log_gz", "kbfs_log.gz", kbfsLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "keybase_log_gz", "keybase_log.gz", svcLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "ek_log_gz", "ek_log.gz", ekLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "updater_log_gz", "updater_log.gz", updaterLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "gui_log_gz", "gui_log.gz", desktopLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "start_log_gz", "start_log.gz", startLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "install_log_gz", "install_log.gz", installLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "system_log_gz", "system_log.gz", systemLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "git_log_gz", "git_log.gz", gitLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "watchdog_log_gz", "watchdog_log.gz", watchdogLog); err != nil {
-		return "", err
-	}
-	if err := addGzippedFile(mpart, "processes_log_gz", "processes_log.gz", processesLog); err != nil {
-		return "", err
-	}
-
-	if len(traceBundle) > 0 {
-		mctx.Debug("trace bundle size: %d", len(traceBundle))
-		if err := addFile(mpart, "trace_tar_gz", "trace.tar.gz", traceBundle); err != nil {
+	// Pulls from the same bundleFiles/bundleBinaryFiles tables buildBundle
+	// uses, so this multipart POST and LogSendToFile's tar.gz can't drift
+	// apart on what's actually in a bundle.
+	for _, e := range bundleFiles {
+		if err := addGzippedFile(mpart, e.postParam, e.postFilename, e.get(b)); err != nil {
 			return "", err
 		}
 	}
-
-	if len(cpuProfileBundle) > 0 {
-		mctx.Debug("CPU profile bundle size: %d", len(cpuProfileBundle))
-		if err := addFile(mpart, "cpu_profile_tar_gz", "cpu_profile.tar.gz", cpuProfileBundle); err != nil {
+	for _, e := range bundleBinaryFiles {
+		data := e.get(b)
+		if len(data) > 0 {
+			mctx.Debug("%s size: %d", e.debugLabel, len(data))
+		}
+		if err := addFile(mpart, e.postParam, e.postFilename, data); err != nil {
 			return "", err
 		}
 	}
@@ -303,10 +376,11 @@ func appendError(log logger.Logger, collected []byte, format string, args ...int
 	return append(collected, []byte("\n"+msg+"\n")...)
 }
 
-// Get logs from the systemd journal. Currently we don't use this for most of
-// our logging, since it's not persisted across boot on some systems. But we do
-// use it for startup logs.
-func tailSystemdJournal(log logger.Logger, userUnits []string, numBytes int) (ret string) {
+// tailSystemdJournalExec gets logs from the systemd journal by shelling out
+// to journalctl. It's kept around as a fallback for platforms where the
+// native sdjournal reader (see log_send_journal_linux.go) isn't available,
+// or can't open the journal at all.
+func tailSystemdJournalExec(log logger.Logger, userUnits []string, numBytes int) (ret string) {
 	log.Debug("+ tailing journalctl for %#v (%d bytes)", userUnits, numBytes)
 	defer func() {
 		log.Debug("- scanned %d bytes", len(ret))
@@ -412,7 +486,7 @@ func tailFile(log logger.Logger, which string, filename string, numBytes int) (r
 	return string(buf), seeked
 }
 
-func addFileToTar(tw *tar.Writer, path string) error {
+func addFileToTar(tw *tar.Writer, path string, redactor *Redactor) error {
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -420,9 +494,13 @@ func addFileToTar(tw *tar.Writer, path string) error {
 	defer file.Close()
 
 	if stat, err := file.Stat(); err == nil {
+		name := filepath.Base(path)
+		if redactor != nil {
+			name = redactor.Scrub(name)
+		}
 		header := tar.Header{
 			Typeflag: tar.TypeReg,
-			Name:     filepath.Base(path),
+			Name:     name,
 			Size:     stat.Size(),
 			Mode:     int64(0600),
 			ModTime:  stat.ModTime(),
@@ -437,7 +515,7 @@ func addFileToTar(tw *tar.Writer, path string) error {
 	return nil
 }
 
-func addFilesToTarGz(log logger.Logger, w io.Writer, paths []string) bool {
+func addFilesToTarGz(log logger.Logger, w io.Writer, paths []string, redactor *Redactor) bool {
 	gw := gzip.NewWriter(w)
 	defer gw.Close()
 	tw := tar.NewWriter(gw)
@@ -445,7 +523,7 @@ func addFilesToTarGz(log logger.Logger, w io.Writer, paths []string) bool {
 
 	added := false
 	for _, path := range paths {
-		err := addFileToTar(tw, path)
+		err := addFileToTar(tw, path, redactor)
 		if err != nil {
 			log.Warning("Error adding %q to tar file: %s", path, err)
 			continue
@@ -456,92 +534,207 @@ func addFilesToTarGz(log logger.Logger, w io.Writer, paths []string) bool {
 	return added
 }
 
-func getBundledFiles(log logger.Logger, files []string, maxFileCount int) []byte {
+func getBundledFiles(log logger.Logger, files []string, maxFileCount int, redactor *Redactor) []byte {
 	// Send the newest files.
 	if len(files) > maxFileCount {
 		files = files[len(files)-maxFileCount:]
 	}
 
 	buf := bytes.NewBuffer(nil)
-	added := addFilesToTarGz(log, buf, files)
+	added := addFilesToTarGz(log, buf, files, redactor)
 	if !added {
 		return nil
 	}
 	return buf.Bytes()
 }
 
-func getTraceBundle(log logger.Logger, traceDir string) []byte {
+func getTraceBundle(log logger.Logger, traceDir string, redactor *Redactor) []byte {
 	traceFiles, err := GetSortedTraceFiles(traceDir)
 	if err != nil {
 		log.Warning("Error getting trace files in %q: %s", traceDir, err)
 		return nil
 	}
 
-	return getBundledFiles(log, traceFiles, MaxTraceFileCount)
+	return getBundledFiles(log, traceFiles, MaxTraceFileCount, redactor)
 }
 
-func getCPUProfileBundle(log logger.Logger, cpuProfileDir string) []byte {
+func getCPUProfileBundle(log logger.Logger, cpuProfileDir string, redactor *Redactor) []byte {
 	cpuProfileFiles, err := GetSortedCPUProfileFiles(cpuProfileDir)
 	if err != nil {
 		log.Warning("Error getting CPU profile files in %q: %s", cpuProfileDir, err)
 		return nil
 	}
 
-	return getBundledFiles(log, cpuProfileFiles, MaxCPUProfileFileCount)
+	return getBundledFiles(log, cpuProfileFiles, MaxCPUProfileFileCount, redactor)
 }
 
-// LogSend sends the tails of log files to kb, and also the last
-// few trace output files.
-func (l *LogSendContext) LogSend(statusJSON, feedback string, sendLogs bool, numBytes int, uid keybase1.UID, installID InstallID, mergeExtendedStatus bool) (string, error) {
+// LogSend sends the tails of log files to kb, and also the last few trace
+// output files. Everything it collects is passed through a Redactor before
+// it's uploaded; pass a LogSendOpts with DisableRedaction set to skip that
+// for debugging, or DryRun set to build the bundle without sending it.
+func (l *LogSendContext) LogSend(statusJSON, feedback string, sendLogs bool, numBytes int, uid keybase1.UID, installID InstallID, mergeExtendedStatus bool, opts ...LogSendOpts) (string, error) {
+	var opt LogSendOpts
+	if len(opts) > 0 {
+		opt = opts[0]
+	}
+
 	mctx := NewMetaContextBackground(l.G()).WithLogTag("LOGSEND")
-	logs := l.Logs
-	var kbfsLog string
-	var svcLog string
-	var ekLog string
-	var desktopLog string
-	var updaterLog string
-	var startLog string
-	var installLog string
-	var systemLog string
-	var gitLog string
-	var traceBundle []byte
-	var cpuProfileBundle []byte
-	var watchdogLog string
-	var processesLog string
-
-	if sendLogs {
-		svcLog = tail(l.G().Log, "service", logs.Service, numBytes)
-		ekLog = tail(l.G().Log, "ek", logs.EK, numBytes)
-		kbfsLog = tail(l.G().Log, "kbfs", logs.Kbfs, numBytes)
-		desktopLog = tail(l.G().Log, "desktop", logs.Desktop, numBytes)
-		updaterLog = tail(l.G().Log, "updater", logs.Updater, numBytes)
-		// We don't use the systemd journal to store regular logs, since on
-		// some systems (e.g. Ubuntu 16.04) it's not persisted across boots.
-		// However we do use it for startup logs, since that's the only place
-		// to get them in systemd mode.
-		if l.G().Env.WantsSystemd() {
-			startLog = tailSystemdJournal(l.G().Log, []string{"keybase.service", "keybase.ek", "kbfs.service", "keybase.gui.service", "keybase-redirector.service"}, numBytes)
-		} else {
-			startLog = tail(l.G().Log, "start", logs.Start, numBytes)
+	b := l.collect(mctx, statusJSON, feedback, sendLogs, numBytes, uid, installID, mergeExtendedStatus, opt)
+
+	if opt.DryRun {
+		var buf bytes.Buffer
+		if _, err := buildBundle(&buf, b); err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		id := hex.EncodeToString(sum[:])
+		mctx.Debug("dry run: built a %d byte bundle without sending it (id %s)", buf.Len(), id)
+		return id, nil
+	}
+
+	return l.post(mctx, b)
+}
+
+// LogSendToFile assembles the same bundle LogSend would upload -- gzipped
+// log tails, trace/CPU-profile tarballs, status JSON, and the processes
+// snapshot -- into a single tar.gz archive on disk at path, for deployments
+// that can't POST to logdump/send (air-gapped installs) or for support
+// engineers who want to inspect a bundle before sending it. It returns the
+// SHA-256 of the archive, used the same way LogSend's logdump ID is.
+func (l *LogSendContext) LogSendToFile(path string, statusJSON, feedback string, sendLogs bool, numBytes int, uid keybase1.UID, installID InstallID, mergeExtendedStatus bool) (string, error) {
+	mctx := NewMetaContextBackground(l.G()).WithLogTag("LOGSEND")
+	b := l.collect(mctx, statusJSON, feedback, sendLogs, numBytes, uid, installID, mergeExtendedStatus, LogSendOpts{})
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := buildBundle(io.MultiWriter(f, hasher), b); err != nil {
+		return "", err
+	}
+
+	id := hex.EncodeToString(hasher.Sum(nil))
+	mctx.Debug("wrote log bundle to %q (logdump id %s)", path, id)
+	return id, nil
+}
+
+// bundleManifest gets written to bundle/manifest.json inside every archive
+// buildBundle produces, so a bundle is self-describing without needing the
+// original LogSendContext around.
+type bundleManifest struct {
+	UID         string    `json:"uid,omitempty"`
+	InstallID   string    `json:"install_id,omitempty"`
+	Feedback    string    `json:"feedback,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Files       []string  `json:"files"`
+}
+
+// bundleFileEntry describes one gzip-compressible text field of a logBundle.
+// It's the single source of truth for what goes into a bundle: buildBundle
+// writes entry.archiveName under bundle/, and post uploads the same data
+// under entry.postParam/entry.postFilename, so the multipart POST and the
+// tar.gz archive can never fork into two different file lists.
+type bundleFileEntry struct {
+	archiveName  string
+	postParam    string
+	postFilename string
+	get          func(b *logBundle) string
+}
+
+var bundleFiles = []bundleFileEntry{
+	{"status.json", "status_gz", "status.gz", func(b *logBundle) string { return b.status }},
+	{"kbfs_log", "kbfs_log_gz", "kbfs_log.gz", func(b *logBundle) string { return b.kbfsLog }},
+	{"keybase_log", "keybase_log_gz", "keybase_log.gz", func(b *logBundle) string { return b.svcLog }},
+	{"ek_log", "ek_log_gz", "ek_log.gz", func(b *logBundle) string { return b.ekLog }},
+	{"gui_log", "gui_log_gz", "gui_log.gz", func(b *logBundle) string { return b.desktopLog }},
+	{"updater_log", "updater_log_gz", "updater_log.gz", func(b *logBundle) string { return b.updaterLog }},
+	{"start_log", "start_log_gz", "start_log.gz", func(b *logBundle) string { return b.startLog }},
+	{"install_log", "install_log_gz", "install_log.gz", func(b *logBundle) string { return b.installLog }},
+	{"system_log", "system_log_gz", "system_log.gz", func(b *logBundle) string { return b.systemLog }},
+	{"git_log", "git_log_gz", "git_log.gz", func(b *logBundle) string { return b.gitLog }},
+	{"watchdog_log", "watchdog_log_gz", "watchdog_log.gz", func(b *logBundle) string { return b.watchdogLog }},
+	{"processes_log", "processes_log_gz", "processes_log.gz", func(b *logBundle) string { return b.processesLog }},
+	{"processes_snapshot.json", "processes_snapshot_gz", "processes_snapshot.gz", func(b *logBundle) string { return b.processesSnapshot }},
+	{"redaction_report", "redaction_report_gz", "redaction_report.gz", func(b *logBundle) string { return b.redactionReport }},
+}
+
+// bundleBinaryEntry describes one already-compressed binary field of a
+// logBundle (a tarball, not plain text), following the same
+// single-source-of-truth rule as bundleFileEntry.
+type bundleBinaryEntry struct {
+	archiveName  string
+	postParam    string
+	postFilename string
+	debugLabel   string
+	get          func(b *logBundle) []byte
+}
+
+var bundleBinaryFiles = []bundleBinaryEntry{
+	{"trace/trace.tar.gz", "trace_tar_gz", "trace.tar.gz", "trace bundle", func(b *logBundle) []byte { return b.traceBundle }},
+	{"trace/cpu_profile.tar.gz", "cpu_profile_tar_gz", "cpu_profile.tar.gz", "CPU profile bundle", func(b *logBundle) []byte { return b.cpuProfileBundle }},
+}
+
+// buildBundle is the common producer behind both LogSend's multipart POST
+// and LogSendToFile's on-disk archive: it writes every file in a logBundle
+// into a stable `bundle/...` directory layout inside a tar.gz written to w,
+// so the two exporters can't end up shipping a different set of files.
+func buildBundle(w io.Writer, b *logBundle) ([]string, error) {
+	gw := gzip.NewWriter(w)
+	defer gw.Close()
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	var files []string
+	write := func(name string, data []byte) error {
+		if len(data) == 0 {
+			return nil
 		}
-		installLog = tail(l.G().Log, "install", logs.Install, numBytes)
-		systemLog = tail(l.G().Log, "system", logs.System, numBytes)
-		gitLog = tail(l.G().Log, "git", logs.Git, numBytes)
-		watchdogLog = tail(l.G().Log, "watchdog", logs.Watchdog, numBytes)
-		if logs.Trace != "" {
-			traceBundle = getTraceBundle(l.G().Log, logs.Trace)
+		header := tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(data)),
+			Mode:     0600,
 		}
-		if logs.CPUProfile != "" {
-			cpuProfileBundle = getCPUProfileBundle(l.G().Log, logs.CPUProfile)
+		if err := tw.WriteHeader(&header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+		files = append(files, name)
+		return nil
+	}
+
+	for _, e := range bundleFiles {
+		if err := write("bundle/"+e.archiveName, []byte(e.get(b))); err != nil {
+			return nil, err
 		}
-		// Only add extended status if we're sending logs
-		if mergeExtendedStatus {
-			statusJSON = l.mergeExtendedStatus(statusJSON)
+	}
+	for _, e := range bundleBinaryFiles {
+		if err := write("bundle/"+e.archiveName, e.get(b)); err != nil {
+			return nil, err
 		}
-		processesLog = keybaseProcessList()
 	}
 
-	return l.post(mctx, statusJSON, feedback, kbfsLog, svcLog, ekLog, desktopLog, updaterLog, startLog, installLog, systemLog, gitLog, watchdogLog, traceBundle, cpuProfileBundle, uid, installID, processesLog)
+	manifest := bundleManifest{
+		UID:         b.uid.String(),
+		InstallID:   string(b.installID),
+		Feedback:    b.feedback,
+		GeneratedAt: time.Now().UTC(),
+		Files:       files,
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := write("bundle/manifest.json", manifestJSON); err != nil {
+		return nil, err
+	}
+
+	return files, nil
 }
 
 // mergeExtendedStatus adds the extended status to the given status json blob.
@@ -573,7 +766,163 @@ func (l *LogSendContext) mergeExtendedStatus(status string) string {
 	return string(fullStatus)
 }
 
-func keybaseProcessList() string {
+// processRecord is everything an on-call engineer triaging a bug report
+// would want to know about one matched keybase/kbfs/updater process.
+type processRecord struct {
+	PID        int32    `json:"pid"`
+	PPID       int32    `json:"ppid"`
+	Name       string   `json:"name"`
+	Cmdline    []string `json:"cmdline,omitempty"`
+	Path       string   `json:"path,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	CreateTime int64    `json:"create_time_ms"`
+	NumThreads int32    `json:"num_threads"`
+	NumFDs     int32    `json:"num_fds"`
+	CPUPercent float64  `json:"cpu_percent"`
+	RSS        uint64   `json:"rss"`
+	VMS        uint64   `json:"vms"`
+}
+
+// processesSnapshot is the structured system+process report we send up in
+// the processes_snapshot_gz multipart field.
+type processesSnapshot struct {
+	Host        *host.InfoStat         `json:"host,omitempty"`
+	CPUPercent  float64                `json:"cpu_percent,omitempty"`
+	Memory      *mem.VirtualMemoryStat `json:"memory,omitempty"`
+	Swap        *mem.SwapMemoryStat    `json:"swap,omitempty"`
+	Load        *load.AvgStat          `json:"load,omitempty"`
+	ConfigDisk  *disk.UsageStat        `json:"config_disk,omitempty"`
+	Processes   []processRecord        `json:"processes"`
+	Connections []gnet.ConnectionStat  `json:"connections,omitempty"`
+}
+
+// keybaseProcessList returns the plain-text process list (for the
+// processes_log_gz field, kept for backward compatibility) and the
+// structured process/system snapshot rendered as JSON (for the newer
+// processes_snapshot_gz field). If JSON serialization fails for some
+// reason, the snapshot string falls back to the same plain-text format.
+func keybaseProcessList(mctx MetaContext) (text string, snapshotJSON string) {
+	snapshot, err := buildProcessesSnapshot(mctx)
+	if err != nil {
+		errText := fmt.Sprintf("error getting processes: %s", err)
+		return errText, errText
+	}
+
+	text = legacyProcessListText(snapshot.Processes)
+
+	asJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		mctx.Debug("error marshaling processes snapshot, falling back to text: %s", err)
+		return text, text
+	}
+	return text, string(asJSON)
+}
+
+func buildProcessesSnapshot(mctx MetaContext) (*processesSnapshot, error) {
+	snapshot := &processesSnapshot{}
+
+	if info, err := host.Info(); err == nil {
+		snapshot.Host = info
+	} else {
+		mctx.Debug("error getting host info: %s", err)
+	}
+	if percents, err := cpu.Percent(time.Second, false); err == nil && len(percents) > 0 {
+		snapshot.CPUPercent = percents[0]
+	} else if err != nil {
+		mctx.Debug("error getting overall CPU percent: %s", err)
+	}
+	if vmem, err := mem.VirtualMemory(); err == nil {
+		snapshot.Memory = vmem
+	} else {
+		mctx.Debug("error getting virtual memory stats: %s", err)
+	}
+	if swap, err := mem.SwapMemory(); err == nil {
+		snapshot.Swap = swap
+	} else {
+		mctx.Debug("error getting swap stats: %s", err)
+	}
+	// load.Avg is unsupported on Windows; that's fine, we just omit it.
+	if avg, err := load.Avg(); err == nil {
+		snapshot.Load = avg
+	}
+	if usage, err := disk.Usage(mctx.G().Env.GetConfigDir()); err == nil {
+		snapshot.ConfigDisk = usage
+	} else {
+		mctx.Debug("error getting disk usage for config dir: %s", err)
+	}
+
+	procs, err := pgrep(keybaseProcessRegexp)
+	if err != nil {
+		return nil, fmt.Errorf("error getting processes: %s", err)
+	}
+
+	matchedPIDs := make(map[int32]bool, len(procs))
+	for _, p := range procs {
+		matchedPIDs[p.Pid] = true
+		snapshot.Processes = append(snapshot.Processes, processRecordFor(p))
+	}
+
+	if conns, err := gnet.Connections("all"); err == nil {
+		for _, c := range conns {
+			if matchedPIDs[c.Pid] {
+				snapshot.Connections = append(snapshot.Connections, c)
+			}
+		}
+	} else {
+		mctx.Debug("error getting network connections: %s", err)
+	}
+
+	return snapshot, nil
+}
+
+// processRecordFor measures CPU usage over a 1s window with
+// p.Percent(time.Second), since in gopsutil/v3 the first zero-interval
+// Percent call on a process just establishes a baseline.
+func processRecordFor(p *process.Process) processRecord {
+	record := processRecord{PID: p.Pid}
+	if ppid, err := p.Ppid(); err == nil {
+		record.PPID = ppid
+	}
+	if name, err := p.Name(); err == nil {
+		record.Name = name
+	}
+	if cmdline, err := p.CmdlineSlice(); err == nil {
+		record.Cmdline = cmdline
+	}
+	if path, err := p.Exe(); err == nil {
+		record.Path = path
+	} else {
+		record.Path = "unable to get process path"
+	}
+	if username, err := p.Username(); err == nil {
+		record.Username = username
+	}
+	if createTime, err := p.CreateTime(); err == nil {
+		record.CreateTime = createTime
+	}
+	if numThreads, err := p.NumThreads(); err == nil {
+		record.NumThreads = numThreads
+	}
+	if numFDs, err := p.NumFDs(); err == nil {
+		record.NumFDs = numFDs
+	}
+	if memInfo, err := p.MemoryInfo(); err == nil {
+		record.RSS = memInfo.RSS
+		record.VMS = memInfo.VMS
+	}
+	if cpuPercent, err := p.Percent(time.Second); err == nil {
+		record.CPUPercent = cpuPercent
+	}
+	return record
+}
+
+// legacyProcessListText renders the pre-gopsutil plain-text process list,
+// for the processes_log_gz field and as a fallback if JSON marshaling of
+// the structured snapshot fails. It's built from the processRecords
+// buildProcessesSnapshot already computed rather than re-deriving them from
+// the underlying *process.Process handles, which would mean every gopsutil
+// call -- including the mandatory 1s CPU sample -- runs twice per process.
+func legacyProcessListText(records []processRecord) string {
 	ret := ""
 	osinfo, err := getOSInfo()
 	if err == nil {
@@ -581,17 +930,8 @@ func keybaseProcessList() string {
 	} else {
 		ret += fmt.Sprintf("could not get OS info for platform %s: %s\n\n", runtime.GOOS, err)
 	}
-
-	processes, err := pgrep(keybaseProcessRegexp)
-	if err != nil {
-		return fmt.Sprintf("error getting processes: %s", err)
-	}
-	for _, process := range processes {
-		path, err := process.Path()
-		if err != nil {
-			path = "unable to get process path"
-		}
-		ret += fmt.Sprintf("%s (%+v)\n", path, process)
+	for _, record := range records {
+		ret += fmt.Sprintf("%s (%+v)\n", record.Path, record)
 	}
 	return ret
 }
@@ -618,16 +958,20 @@ func getOSInfo() (string, error) {
 
 var keybaseProcessRegexp = regexp.MustCompile(`(?i:kbfs|keybase|upd)`)
 
-func pgrep(matcher *regexp.Regexp) ([]ps.Process, error) {
-	processes, err := ps.Processes()
+func pgrep(matcher *regexp.Regexp) ([]*process.Process, error) {
+	all, err := process.Processes()
 	if err != nil {
 		return nil, err
 	}
-	var filteredProcesses []ps.Process
-	for _, process := range processes {
-		if matcher.MatchString(process.Executable()) {
-			filteredProcesses = append(filteredProcesses, process)
+	var filtered []*process.Process
+	for _, p := range all {
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		if matcher.MatchString(name) {
+			filtered = append(filtered, p)
 		}
 	}
-	return filteredProcesses, nil
+	return filtered, nil
 }
@@ -0,0 +1,150 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build linux
+// +build linux
+
+package libkb
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/sdjournal"
+	"github.com/keybase/client/go/logger"
+)
+
+// journalReader is a thin wrapper over sdjournal.Journal that knows how to
+// match on our units and walk backward from the tail.
+type journalReader struct {
+	j *sdjournal.Journal
+}
+
+// newJournalReader opens the systemd journal and adds match filters so only
+// entries from one of the given systemd units (user-unit or system-unit)
+// are returned.
+func newJournalReader(units []string) (*journalReader, error) {
+	j, err := sdjournal.NewJournal()
+	if err != nil {
+		return nil, err
+	}
+	for i, unit := range units {
+		if i > 0 {
+			if err := j.AddDisjunction(); err != nil {
+				j.Close()
+				return nil, err
+			}
+		}
+		if err := j.AddMatch("_SYSTEMD_USER_UNIT=" + unit); err != nil {
+			j.Close()
+			return nil, err
+		}
+		if err := j.AddDisjunction(); err != nil {
+			j.Close()
+			return nil, err
+		}
+		if err := j.AddMatch("_SYSTEMD_UNIT=" + unit); err != nil {
+			j.Close()
+			return nil, err
+		}
+	}
+	return &journalReader{j: j}, nil
+}
+
+func (r *journalReader) Close() error {
+	return r.j.Close()
+}
+
+// tailLines seeks to the end of the (filtered) journal and walks backward,
+// collecting up to n entries or until the accumulated text reaches
+// maxBytes, whichever comes first. Entries are returned in chronological
+// order.
+func (r *journalReader) tailLines(n, maxBytes int) ([]string, error) {
+	if err := r.j.SeekTail(); err != nil {
+		return nil, err
+	}
+	var lines []string
+	total := 0
+	for i := 0; i < n && total < maxBytes; i++ {
+		c, err := r.j.Previous()
+		if err != nil {
+			return nil, err
+		}
+		if c == 0 {
+			break
+		}
+		entry, err := r.j.GetEntry()
+		if err != nil {
+			return nil, err
+		}
+		line := formatJournalEntry(entry)
+		lines = append(lines, line)
+		total += len(line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines, nil
+}
+
+func formatJournalEntry(entry *sdjournal.JournalEntry) string {
+	message := entry.Fields["MESSAGE"]
+	unit := entry.Fields["_SYSTEMD_UNIT"]
+	if unit == "" {
+		unit = entry.Fields["_SYSTEMD_USER_UNIT"]
+	}
+	level := "info"
+	if priority, err := strconv.Atoi(entry.Fields["PRIORITY"]); err == nil {
+		level = journalPriorityName(priority)
+	}
+	ts := time.Unix(0, int64(entry.RealtimeTimestamp)*int64(time.Microsecond))
+	return fmt.Sprintf("%s %s %s: %s", ts.Format(time.RFC3339), level, unit, message)
+}
+
+var journalPriorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+func journalPriorityName(priority int) string {
+	if priority < 0 || priority >= len(journalPriorityNames) {
+		return "info"
+	}
+	return journalPriorityNames[priority]
+}
+
+// tailSystemdJournal reads the tail of the systemd journal natively via
+// sdjournal, without shelling out to journalctl (which fails on systems,
+// e.g. Debian Stretch, where the invoking user lacks permission to read
+// system journals). If the journal can't be opened at all -- most commonly
+// "no journal files", which happens in containers where systemd isn't
+// running -- it falls back to the journalctl subprocess.
+func tailSystemdJournal(log logger.Logger, userUnits []string, numBytes int) (ret string) {
+	log.Debug("+ natively tailing systemd journal for %#v (%d bytes)", userUnits, numBytes)
+	defer func() {
+		log.Debug("- scanned %d bytes", len(ret))
+	}()
+
+	if len(userUnits) == 0 {
+		panic("without any units we would scrape the whole system journal!!!")
+	}
+
+	reader, err := newJournalReader(userUnits)
+	if err != nil {
+		log.Debug("falling back to journalctl subprocess: error opening journal natively: %s", err)
+		return tailSystemdJournalExec(log, userUnits, numBytes)
+	}
+	defer reader.Close()
+
+	// journalctl doesn't provide a "last N bytes" flag directly, so we
+	// estimate a line count the same way the journalctl fallback does.
+	guessedLines := numBytes / 150
+	lines, err := reader.tailLines(guessedLines, numBytes*2)
+	if err != nil {
+		if strings.Contains(err.Error(), "no journal files") {
+			log.Debug("falling back to journalctl subprocess: %s", err)
+			return tailSystemdJournalExec(log, userUnits, numBytes)
+		}
+		return string(appendError(log, nil, "Error reading systemd journal natively: %s", err))
+	}
+	return strings.Join(lines, "\n")
+}
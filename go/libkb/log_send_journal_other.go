@@ -0,0 +1,15 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build !linux
+// +build !linux
+
+package libkb
+
+import "github.com/keybase/client/go/logger"
+
+// tailSystemdJournal on non-Linux platforms just shells out to journalctl;
+// the native sdjournal reader is Linux-only.
+func tailSystemdJournal(log logger.Logger, userUnits []string, numBytes int) (ret string) {
+	return tailSystemdJournalExec(log, userUnits, numBytes)
+}
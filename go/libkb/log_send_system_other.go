@@ -0,0 +1,16 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build !windows
+// +build !windows
+
+package libkb
+
+import "github.com/keybase/client/go/logger"
+
+// tailSystemLog on non-Windows platforms is just the regular file tail;
+// system diagnostics there live in a text log, unlike Windows where they're
+// in the Event Log.
+func tailSystemLog(log logger.Logger, stem string, numBytes int) string {
+	return tail(log, "system", stem, numBytes)
+}
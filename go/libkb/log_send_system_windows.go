@@ -0,0 +1,136 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build windows
+// +build windows
+
+package libkb
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+)
+
+// windowsEventLogXPath selects events from the last 24 hours. We don't
+// filter on provider name here since we also want to catch events whose
+// message merely mentions Keybase; that filtering happens client-side in
+// eventMatchesKeybase.
+const windowsEventLogXPath = `*[System[TimeCreated[timediff(@SystemTime) <= 86400000]]]`
+
+var windowsEventLogChannels = []string{"Application", "System"}
+
+// tailSystemLog on Windows queries the Application and System event log
+// channels for Keybase-related events, since system diagnostics there live
+// in the Event Log rather than a text file.
+func tailSystemLog(log logger.Logger, stem string, numBytes int) (ret string) {
+	log.Debug("+ tailing Windows event log (%d bytes)", numBytes)
+	defer func() {
+		log.Debug("- scanned %d bytes", len(ret))
+	}()
+
+	var lines []string
+	for _, channel := range windowsEventLogChannels {
+		channelLines, err := queryWindowsEventLog(channel)
+		if err != nil {
+			log.Debug("error querying %q event log: %s", channel, err)
+			lines = append(lines, fmt.Sprintf("error querying %s event log: %s", channel, err))
+			continue
+		}
+		lines = append(lines, channelLines...)
+	}
+
+	ret = strings.Join(lines, "\n")
+	if len(ret) > numBytes {
+		ret = ret[len(ret)-numBytes:]
+	}
+	return ret
+}
+
+func queryWindowsEventLog(channel string) ([]string, error) {
+	cmd := exec.Command("wevtutil", "qe", channel, "/q:"+windowsEventLogXPath, "/f:RenderedXml", "/rd:true", "/c:2000")
+	out, err := cmd.Output()
+	if err != nil {
+		// Some channels (e.g. Security) deny access to non-admin users;
+		// that's not fatal, we just skip them. wevtutil reports this on
+		// stderr, which Output keeps in the ExitError.
+		if exitErr, ok := err.(*exec.ExitError); ok && strings.Contains(string(exitErr.Stderr), "Access is denied") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return formatWindowsEvents(out)
+}
+
+type windowsEvent struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     int    `xml:"EventID"`
+		Level       int    `xml:"Level"`
+		Channel     string `xml:"Channel"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+	} `xml:"System"`
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	} `xml:"RenderingInfo"`
+}
+
+// formatWindowsEvents parses the concatenated <Event>...</Event> elements
+// that `wevtutil qe /f:RenderedXml` prints (with no enclosing root) and renders each
+// one matching Keybase as "time level channel provider(eventID): message".
+func formatWindowsEvents(xmlBytes []byte) ([]string, error) {
+	var wrapped []byte
+	wrapped = append(wrapped, []byte("<Events>")...)
+	wrapped = append(wrapped, xmlBytes...)
+	wrapped = append(wrapped, []byte("</Events>")...)
+
+	var events struct {
+		Event []windowsEvent `xml:"Event"`
+	}
+	if err := xml.Unmarshal(wrapped, &events); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, e := range events.Event {
+		if !eventMatchesKeybase(e) {
+			continue
+		}
+		lines = append(lines, formatWindowsEvent(e))
+	}
+	return lines, nil
+}
+
+func eventMatchesKeybase(e windowsEvent) bool {
+	switch e.System.Provider.Name {
+	case "Keybase", "KBFS", "keybase-redirector":
+		return true
+	}
+	return strings.Contains(e.RenderingInfo.Message, "Keybase")
+}
+
+func formatWindowsEvent(e windowsEvent) string {
+	return fmt.Sprintf("%s %s %s %s(%d): %s",
+		e.System.TimeCreated.SystemTime,
+		windowsEventLevelName(e.System.Level),
+		e.System.Channel,
+		e.System.Provider.Name,
+		e.System.EventID,
+		strings.TrimSpace(e.RenderingInfo.Message))
+}
+
+var windowsEventLevelNames = map[int]string{1: "critical", 2: "error", 3: "warning", 4: "info", 5: "verbose"}
+
+func windowsEventLevelName(level int) string {
+	if name, ok := windowsEventLevelNames[level]; ok {
+		return name
+	}
+	return "info"
+}
@@ -1,13 +1,31 @@
 package libkb
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/keybase/client/go/protocol/keybase1"
+	context "golang.org/x/net/context"
 )
 
+// defaultWotVouchFetchConcurrency is how many pending vouches we'll expand
+// and standardize at once if MetaContext/Env doesn't override it.
+const defaultWotVouchFetchConcurrency = 8
+
+// wotVouchFetchConcurrency returns the configured worker pool size for
+// FetchPendingWotVouches, falling back to defaultWotVouchFetchConcurrency.
+func wotVouchFetchConcurrency(mctx MetaContext) int {
+	if n := mctx.G().Env.GetWotVouchFetchConcurrency(); n > 0 {
+		return n
+	}
+	return defaultWotVouchFetchConcurrency
+}
+
 func getWotVouchChainLink(mctx MetaContext, uid keybase1.UID, sigID keybase1.SigID) (cl *WotVouchChainLink, voucher *User, err error) {
 	user, err := LoadUser(NewLoadUserArgWithMetaContext(mctx).WithUID(uid))
 	if err != nil {
@@ -98,6 +116,10 @@ func standardizeConfidence(mctx MetaContext, expansionConfidence map[string]inte
 		}
 		expansionConfidence["vouched_by"] = vouchedByUsernames
 	}
+	// reach into expansionConfidence and validate the OIDC proof fields if present
+	if err := validateOIDCConfidence(mctx, expansionConfidence); err != nil {
+		return nil, err
+	}
 	// now expansionConfidence should match keybase1.Confidence, so serialize and deserialize
 	// to do the recursive type conversion
 	asJsonBytes, err := json.Marshal(expansionConfidence)
@@ -111,47 +133,233 @@ func standardizeConfidence(mctx MetaContext, expansionConfidence map[string]inte
 	return &confidence, nil
 }
 
+// validateOIDCConfidence checks the oidc_* fields of a vouch's confidence
+// blob when it has an oidc_issuer: the issuer must be on the
+// client-configured allow-list, oidc_token_hash must be present and look
+// like a base64-encoded SHA-256 digest, and dpop_key_thumbprint, if given,
+// must be well-formed. A blob without oidc_issuer passes unchecked, whatever
+// other oidc_* fields it carries. It doesn't have the raw JWT to check against -- that happens
+// out-of-band in VerifyOIDCVouchToken -- it only validates what's going onto
+// the sigchain-anchored expansion.
+func validateOIDCConfidence(mctx MetaContext, expansionConfidence map[string]interface{}) error {
+	issuerRaw, ok := expansionConfidence["oidc_issuer"]
+	if !ok {
+		return nil
+	}
+	issuer, ok := issuerRaw.(string)
+	if !ok {
+		return fmt.Errorf("cannot convert %v into oidc_issuer", issuerRaw)
+	}
+	if !oidcIssuerAllowed(mctx.G().Env.GetWotOIDCIssuerAllowlist(), issuer) {
+		return fmt.Errorf("oidc_issuer %q is not in the configured allow-list", issuer)
+	}
+
+	hashRaw, ok := expansionConfidence["oidc_token_hash"]
+	if !ok {
+		return fmt.Errorf("oidc_issuer given without oidc_token_hash")
+	}
+	hashStr, ok := hashRaw.(string)
+	if !ok {
+		return fmt.Errorf("cannot convert %v into oidc_token_hash", hashRaw)
+	}
+	if _, err := validateOIDCTokenHash(hashStr); err != nil {
+		return err
+	}
+	return validateDpopKeyThumbprint(expansionConfidence)
+}
+
+// validateDpopKeyThumbprint checks that dpop_key_thumbprint, if present,
+// looks like a base64url-encoded RFC 7638 JWK thumbprint (a 32-byte
+// SHA-256 digest). Most OIDC issuers don't support DPoP, so the field is
+// optional and its absence isn't an error.
+func validateDpopKeyThumbprint(expansionConfidence map[string]interface{}) error {
+	raw, ok := expansionConfidence["dpop_key_thumbprint"]
+	if !ok {
+		return nil
+	}
+	thumbprint, ok := raw.(string)
+	if !ok {
+		return fmt.Errorf("cannot convert %v into dpop_key_thumbprint", raw)
+	}
+	digest, err := base64.RawURLEncoding.DecodeString(thumbprint)
+	if err != nil {
+		return fmt.Errorf("dpop_key_thumbprint is not valid base64url: %s", err.Error())
+	}
+	if len(digest) != sha256.Size {
+		return fmt.Errorf("dpop_key_thumbprint is %d bytes, expected a %d-byte SHA-256 digest", len(digest), sha256.Size)
+	}
+	return nil
+}
+
+func oidcIssuerAllowed(allowlist []string, issuer string) bool {
+	for _, allowed := range allowlist {
+		if allowed == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// validateOIDCTokenHash checks that hashStr base64-decodes to a 32-byte
+// SHA-256 digest, and returns the raw digest.
+func validateOIDCTokenHash(hashStr string) ([]byte, error) {
+	digest, err := base64.StdEncoding.DecodeString(hashStr)
+	if err != nil {
+		return nil, fmt.Errorf("oidc_token_hash is not valid base64: %s", err.Error())
+	}
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("oidc_token_hash is %d bytes, expected a %d-byte SHA-256 digest", len(digest), sha256.Size)
+	}
+	return digest, nil
+}
+
+type oidcClaims struct {
+	Issuer   string `json:"iss"`
+	Audience string `json:"aud"`
+	Subject  string `json:"sub"`
+}
+
+// VerifyOIDCVouchToken lets a relying party (typically the vouchee; nothing
+// in this file, including assertVouchIsForMe, calls it) independently confirm that a voucher really did see a
+// valid OIDC ID token, given the raw JWT out-of-band -- without the token
+// itself ever living on the sigchain. It re-derives the token's SHA-256
+// hash and compares issuer/audience/subject against what the voucher put in
+// the vouch's confidence; it does not verify the JWT's own signature, since
+// that's the issuer's job and isn't what's being attested to here.
+func VerifyOIDCVouchToken(confidence keybase1.Confidence, rawJWT string) error {
+	parts := strings.Split(rawJWT, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed JWT: expected 3 dot-separated parts, got %d", len(parts))
+	}
+
+	sum := sha256.Sum256([]byte(rawJWT))
+	if base64.StdEncoding.EncodeToString(sum[:]) != confidence.OidcTokenHash {
+		return fmt.Errorf("JWT does not match the hash recorded in the vouch")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("error decoding JWT payload: %s", err.Error())
+	}
+	var claims oidcClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return fmt.Errorf("error parsing JWT claims: %s", err.Error())
+	}
+	if claims.Issuer != confidence.OidcIssuer {
+		return fmt.Errorf("JWT issuer %q doesn't match vouch issuer %q", claims.Issuer, confidence.OidcIssuer)
+	}
+	if claims.Audience != confidence.OidcAudience {
+		return fmt.Errorf("JWT audience %q doesn't match vouch audience %q", claims.Audience, confidence.OidcAudience)
+	}
+	if claims.Subject != confidence.OidcSubject {
+		return fmt.Errorf("JWT subject %q doesn't match vouch subject %q", claims.Subject, confidence.OidcSubject)
+	}
+	return nil
+}
+
+// jwkThumbprint computes the RFC 7638 JWK thumbprint of jwkJSON: the
+// base64url-encoded SHA-256 hash of the JWK's required members, serialized
+// in lexicographic key order with no whitespace. encoding/json already
+// marshals map keys in sorted order, which is exactly what RFC 7638 asks
+// for, so we don't need a bespoke canonicalizer. Only RSA and EC keys are
+// supported, since those are what DPoP proofs use in practice.
+func jwkThumbprint(jwkJSON string) (string, error) {
+	var jwk map[string]interface{}
+	if err := json.Unmarshal([]byte(jwkJSON), &jwk); err != nil {
+		return "", fmt.Errorf("error parsing JWK: %s", err.Error())
+	}
+	kty, _ := jwk["kty"].(string)
+
+	var members map[string]interface{}
+	switch kty {
+	case "RSA":
+		members = map[string]interface{}{"e": jwk["e"], "kty": kty, "n": jwk["n"]}
+	case "EC":
+		members = map[string]interface{}{"crv": jwk["crv"], "kty": kty, "x": jwk["x"], "y": jwk["y"]}
+	default:
+		return "", fmt.Errorf("unsupported JWK key type %q", kty)
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// VerifyOIDCVouchDPoPProof lets a relying party confirm that a DPoP proof
+// key the voucher presented out-of-band (jwkJSON, the JWK from the proof's
+// "jwk" header) matches the thumbprint recorded in the vouch's confidence.
+// It's a no-op if the vouch didn't record one, since DPoP is optional.
+func VerifyOIDCVouchDPoPProof(confidence keybase1.Confidence, jwkJSON string) error {
+	if confidence.DpopKeyThumbprint == "" {
+		return nil
+	}
+	thumbprint, err := jwkThumbprint(jwkJSON)
+	if err != nil {
+		return err
+	}
+	if thumbprint != confidence.DpopKeyThumbprint {
+		return fmt.Errorf("DPoP proof key does not match the thumbprint recorded in the vouch")
+	}
+	return nil
+}
+
 type wotExpansionDetails struct {
 	User                wotExpansionUser       `json:"user"`
 	ExpansionConfidence map[string]interface{} `json:"confidence"`
 	VouchTexts          []string               `json:"vouch_text"`
 }
 
-func transformPending(mctx MetaContext, serverPending apiPendingWot) (res keybase1.PendingVouch, err error) {
-	// load the voucher and fetch the relevant chain link
+// fetchWotExpansion loads the voucher's sigchain, pulls out the sig
+// expansion for a single pending vouch, and checks that it's actually
+// about us. It does not touch the UPAKLoader cache or build the final
+// keybase1.PendingVouch, so that callers can batch those steps across
+// many pending vouches at once.
+func fetchWotExpansion(mctx MetaContext, serverPending apiPendingWot) (voucher *User, wotObj wotExpansionDetails, err error) {
 	wotVouchLink, voucher, err := getWotVouchChainLink(mctx, serverPending.UID, serverPending.SigID)
 	if err != nil {
-		return res, fmt.Errorf("error finding the pending vouch in the voucher's sigchain: %s", err.Error())
+		return nil, wotObj, fmt.Errorf("error finding the pending vouch in the voucher's sigchain: %s", err.Error())
 	}
-	// extract the sig expansion
 	expansionObject, err := ExtractExpansionObj(wotVouchLink.ExpansionID, serverPending.ExpansionJSON)
 	if err != nil {
-		return res, fmt.Errorf("error extracting and validating the expansion: %s", err.Error())
+		return nil, wotObj, fmt.Errorf("error extracting and validating the expansion: %s", err.Error())
 	}
-	// load it into the right type for web-of-trust vouching
-	var wotObj wotExpansionDetails
-	err = json.Unmarshal(expansionObject, &wotObj)
-	if err != nil {
-		return res, fmt.Errorf("error casting expansion object to expected web-of-trust schema: %s", err.Error())
+	if err = json.Unmarshal(expansionObject, &wotObj); err != nil {
+		return nil, wotObj, fmt.Errorf("error casting expansion object to expected web-of-trust schema: %s", err.Error())
 	}
-	err = assertVouchIsForMe(mctx, wotObj.User)
-	if err != nil {
+	if err = assertVouchIsForMe(mctx, wotObj.User); err != nil {
 		mctx.Debug("web-of-trust pending vouch user-section doesn't look right: %+v", wotObj.User)
-		return res, fmt.Errorf("error verifying user section of web-of-trust expansion: %s", err.Error())
+		return nil, wotObj, fmt.Errorf("error verifying user section of web-of-trust expansion: %s", err.Error())
 	}
-	// convert the confidence object that's in the expansion to the standard type in keybase1
+	return voucher, wotObj, nil
+}
+
+// finishTransformPending takes an already-fetched expansion and standardizes
+// its confidence blob into a keybase1.PendingVouch.
+func finishTransformPending(mctx MetaContext, sigID keybase1.SigID, voucher *User, wotObj wotExpansionDetails) (res keybase1.PendingVouch, err error) {
 	confidence, err := standardizeConfidence(mctx, wotObj.ExpansionConfidence)
 	if err != nil {
 		return res, fmt.Errorf("error standardizing confidence: %s", err.Error())
 	}
-	// build a PendingVouch
-	vouch := keybase1.PendingVouch{
+	return keybase1.PendingVouch{
 		Voucher:    voucher.ToUserVersion(),
-		Proof:      serverPending.SigID,
+		Proof:      sigID,
 		VouchTexts: wotObj.VouchTexts,
 		Confidence: *confidence,
+	}, nil
+}
+
+// transformPending is kept around as a sequential, one-shot equivalent of
+// fetchWotExpansion+finishTransformPending for callers that don't need the
+// batching FetchPendingWotVouches does.
+func transformPending(mctx MetaContext, serverPending apiPendingWot) (res keybase1.PendingVouch, err error) {
+	voucher, wotObj, err := fetchWotExpansion(mctx, serverPending)
+	if err != nil {
+		return res, err
 	}
-	return vouch, nil
+	return finishTransformPending(mctx, serverPending.SigID, voucher, wotObj)
 }
 
 type apiPendingWot struct {
@@ -166,6 +374,190 @@ type GetPendingWotVouches struct {
 	Pending []apiPendingWot `json:"pending"`
 }
 
+// FetchPendingWotVouchesError is returned by FetchPendingWotVouches when one
+// or more pending vouches failed to expand. It collects every failure
+// rather than stopping at the first bad expansion, so callers can see
+// exactly which sigIDs were affected; the call still returns no vouches
+// alongside it, not even the ones that expanded fine.
+type FetchPendingWotVouchesError struct {
+	Failures map[keybase1.SigID]error
+}
+
+func (e *FetchPendingWotVouchesError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for sigID, ferr := range e.Failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", sigID, ferr.Error()))
+	}
+	sort.Strings(msgs)
+	return fmt.Sprintf("failed to expand %d pending web-of-trust vouch(es): %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// wotExpansionResult pairs a server-reported pending vouch with the voucher
+// and expansion we fetched for it.
+type wotExpansionResult struct {
+	pending apiPendingWot
+	voucher *User
+	wotObj  wotExpansionDetails
+}
+
+// runWorkerPool runs fn(i) for every i in [0, n) across a bounded pool of
+// numWorkers goroutines (clamped to n, and to at least 1). If ctx is
+// canceled, no further indices are dispatched; either way it returns only
+// after every worker (and so every fn call already started) has returned,
+// with ctx.Err() as its result. fn is responsible for recording
+// its own per-index outcome (e.g. into a pre-sized slice); runWorkerPool
+// only owns dispatch and cancellation, so unrelated batches of indexed work
+// can share this instead of each hand-rolling the same channel plumbing.
+func runWorkerPool(ctx context.Context, n, numWorkers int, fn func(i int)) error {
+	if n == 0 {
+		return nil
+	}
+	if numWorkers > n {
+		numWorkers = n
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				fn(idx)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(work)
+		for i := 0; i < n; i++ {
+			select {
+			case work <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// fetchWotExpansions expands every pending vouch through a bounded worker
+// pool, preserving the server's original order in the returned slice. It
+// bails out early if mctx.Ctx() is canceled, and aggregates all per-vouch
+// failures into a single FetchPendingWotVouchesError instead of failing on
+// the first one.
+func fetchWotExpansions(mctx MetaContext, pending []apiPendingWot) ([]wotExpansionResult, error) {
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	ordered := make([]wotExpansionResult, len(pending))
+	var mu sync.Mutex
+	failures := make(map[keybase1.SigID]error)
+
+	err := runWorkerPool(mctx.Ctx(), len(pending), wotVouchFetchConcurrency(mctx), func(i int) {
+		p := pending[i]
+		voucher, wotObj, err := fetchWotExpansion(mctx, p)
+		if err != nil {
+			mctx.Debug("error validating server-reported pending web-of-trust vouch %s: %s", p.SigID, err.Error())
+			mu.Lock()
+			failures[p.SigID] = err
+			mu.Unlock()
+			return
+		}
+		ordered[i] = wotExpansionResult{p, voucher, wotObj}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		return nil, &FetchPendingWotVouchesError{Failures: failures}
+	}
+	return ordered, nil
+}
+
+// prefetchVouchedByUsernames collects every unique UID mentioned in the
+// "vouched_by" list across all of the given expansions and warms the
+// UPAKLoader cache for them in one bounded-concurrency pass, so the
+// per-vouch standardizeConfidence calls that follow don't each pay for a
+// cold LookupUsername round-trip.
+func prefetchVouchedByUsernames(mctx MetaContext, expansions []wotExpansionResult) {
+	uidSet := make(map[keybase1.UID]bool)
+	for _, exp := range expansions {
+		vouchedByRaw, ok := exp.wotObj.ExpansionConfidence["vouched_by"]
+		if !ok {
+			continue
+		}
+		vouchedByUIDs, ok := vouchedByRaw.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, vuid := range vouchedByUIDs {
+			if uidStr, ok := vuid.(string); ok {
+				uidSet[keybase1.UID(uidStr)] = true
+			}
+		}
+	}
+	if len(uidSet) == 0 {
+		return
+	}
+
+	uids := make([]keybase1.UID, 0, len(uidSet))
+	for uid := range uidSet {
+		uids = append(uids, uid)
+	}
+
+	_ = runWorkerPool(mctx.Ctx(), len(uids), wotVouchFetchConcurrency(mctx), func(i int) {
+		uid := uids[i]
+		if _, err := mctx.G().GetUPAKLoader().LookupUsername(mctx.Ctx(), uid); err != nil {
+			mctx.Debug("prefetch: error warming username cache for %s: %s", uid, err.Error())
+		}
+	})
+}
+
+// buildPendingVouches standardizes each expansion's confidence blob into a
+// keybase1.PendingVouch through the same bounded worker pool pattern as
+// fetchWotExpansions, again preserving server order and aggregating errors.
+func buildPendingVouches(mctx MetaContext, expansions []wotExpansionResult) ([]keybase1.PendingVouch, error) {
+	if len(expansions) == 0 {
+		return nil, nil
+	}
+
+	ordered := make([]keybase1.PendingVouch, len(expansions))
+	var mu sync.Mutex
+	failures := make(map[keybase1.SigID]error)
+
+	err := runWorkerPool(mctx.Ctx(), len(expansions), wotVouchFetchConcurrency(mctx), func(i int) {
+		exp := expansions[i]
+		vouch, err := finishTransformPending(mctx, exp.pending.SigID, exp.voucher, exp.wotObj)
+		if err != nil {
+			mu.Lock()
+			failures[exp.pending.SigID] = err
+			mu.Unlock()
+			return
+		}
+		ordered[i] = vouch
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		return nil, &FetchPendingWotVouchesError{Failures: failures}
+	}
+	return ordered, nil
+}
+
+// FetchPendingWotVouches fetches the pending web-of-trust vouches for the
+// logged-in user and expands each one's sig expansion into a
+// keybase1.PendingVouch. Expansion and username lookups are both done
+// through bounded worker pools (see wotVouchFetchConcurrency) instead of
+// sequentially, since a long pending list would otherwise mean dozens of
+// blocking network round-trips.
 func FetchPendingWotVouches(mctx MetaContext) (res []keybase1.PendingVouch, err error) {
 	defer mctx.Trace("FetchPendingWotVouches", func() error { return err })()
 	apiArg := APIArg{
@@ -178,13 +570,21 @@ func FetchPendingWotVouches(mctx MetaContext) (res []keybase1.PendingVouch, err
 		mctx.Debug("error fetching pending web-of-trust vouches: %s", err.Error())
 		return nil, err
 	}
-	for _, apiPending := range response.Pending {
-		newPending, err := transformPending(mctx, apiPending)
-		if err != nil {
-			mctx.Debug("error validating server-reported pending web-of-trust vouches: %s", err.Error())
-			return nil, err
-		}
-		res = append(res, newPending)
+
+	expansions, err := fetchWotExpansions(mctx, response.Pending)
+	if err != nil {
+		mctx.Debug("error validating server-reported pending web-of-trust vouches: %s", err.Error())
+		return nil, err
+	}
+
+	// Warm the username cache for every voucher mentioned anywhere in the
+	// batch before we standardize confidence for any single vouch.
+	prefetchVouchedByUsernames(mctx, expansions)
+
+	res, err = buildPendingVouches(mctx, expansions)
+	if err != nil {
+		mctx.Debug("error validating server-reported pending web-of-trust vouches: %s", err.Error())
+		return nil, err
 	}
 	mctx.Debug("found %d pending web-of-trust vouches", len(res))
 	return res, nil
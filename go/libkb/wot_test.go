@@ -0,0 +1,241 @@
+package libkb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	context "golang.org/x/net/context"
+)
+
+func TestRunWorkerPoolPreservesOrder(t *testing.T) {
+	const n = 50
+	results := make([]int, n)
+	err := runWorkerPool(context.Background(), n, 8, func(i int) {
+		results[i] = i * i
+	})
+	require.NoError(t, err)
+	for i := 0; i < n; i++ {
+		require.Equal(t, i*i, results[i])
+	}
+}
+
+func TestRunWorkerPoolClampsWorkerCount(t *testing.T) {
+	var concurrent int32
+	var maxConcurrent int32
+	start := make(chan struct{})
+	// runWorkerPool doesn't return until every fn call has, so the workers
+	// have to be released from the start barrier while it's still running.
+	time.AfterFunc(50*time.Millisecond, func() { close(start) })
+	err := runWorkerPool(context.Background(), 3, 8, func(i int) {
+		<-start
+		cur := atomic.AddInt32(&concurrent, 1)
+		for {
+			max := atomic.LoadInt32(&maxConcurrent)
+			if cur <= max || atomic.CompareAndSwapInt32(&maxConcurrent, max, cur) {
+				break
+			}
+		}
+		atomic.AddInt32(&concurrent, -1)
+	})
+	require.NoError(t, err)
+	require.LessOrEqual(t, int(maxConcurrent), 3)
+}
+
+func TestRunWorkerPoolRespectsCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var ran int32
+	cancel()
+	err := runWorkerPool(ctx, 100, 4, func(i int) {
+		atomic.AddInt32(&ran, 1)
+	})
+	require.Error(t, err)
+	require.Less(t, int(ran), 100)
+}
+
+func TestRunWorkerPoolEmpty(t *testing.T) {
+	called := false
+	err := runWorkerPool(context.Background(), 0, 8, func(i int) { called = true })
+	require.NoError(t, err)
+	require.False(t, called)
+}
+
+func TestFetchPendingWotVouchesErrorMessage(t *testing.T) {
+	e := &FetchPendingWotVouchesError{
+		Failures: map[keybase1.SigID]error{
+			keybase1.SigID("sig-b"): fmt.Errorf("boom-b"),
+			keybase1.SigID("sig-a"): fmt.Errorf("boom-a"),
+		},
+	}
+	msg := e.Error()
+	require.Contains(t, msg, "2 pending web-of-trust vouch")
+	require.Contains(t, msg, "sig-a: boom-a")
+	require.Contains(t, msg, "sig-b: boom-b")
+	// sig-a sorts before sig-b
+	require.True(t, indexOf(msg, "sig-a") < indexOf(msg, "sig-b"))
+}
+
+func indexOf(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if s[i:i+len(substr)] == substr {
+			return i
+		}
+	}
+	return -1
+}
+
+// fixtureJWT builds a realistic-looking HS256 JWT signed with an in-test
+// issuer key. VerifyOIDCVouchToken never checks the signature, but we sign
+// it anyway so the fixture matches what a real OIDC provider would hand out.
+func fixtureJWT(t *testing.T, issuer, audience, subject string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claimsJSON, err := json.Marshal(oidcClaims{Issuer: issuer, Audience: audience, Subject: subject})
+	require.NoError(t, err)
+	payload := base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signingInput := header + "." + payload
+
+	mac := hmac.New(sha256.New, []byte("test-issuer-signing-key"))
+	mac.Write([]byte(signingInput))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return signingInput + "." + sig
+}
+
+func confidenceForJWT(t *testing.T, jwt string) keybase1.Confidence {
+	sum := sha256.Sum256([]byte(jwt))
+	parts := struct {
+		Iss string `json:"iss"`
+		Aud string `json:"aud"`
+		Sub string `json:"sub"`
+	}{}
+	segments := splitJWT(t, jwt)
+	payload, err := base64.RawURLEncoding.DecodeString(segments[1])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(payload, &parts))
+	return keybase1.Confidence{
+		OidcIssuer:    parts.Iss,
+		OidcAudience:  parts.Aud,
+		OidcSubject:   parts.Sub,
+		OidcTokenHash: base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+func splitJWT(t *testing.T, jwt string) []string {
+	var segments []string
+	start := 0
+	for i, c := range jwt {
+		if c == '.' {
+			segments = append(segments, jwt[start:i])
+			start = i + 1
+		}
+	}
+	segments = append(segments, jwt[start:])
+	require.Len(t, segments, 3)
+	return segments
+}
+
+func TestVerifyOIDCVouchTokenSucceedsOnMatch(t *testing.T) {
+	jwt := fixtureJWT(t, "https://issuer.example.com", "keybase-wot", "user-42")
+	confidence := confidenceForJWT(t, jwt)
+	require.NoError(t, VerifyOIDCVouchToken(confidence, jwt))
+}
+
+func TestVerifyOIDCVouchTokenRejectsHashMismatch(t *testing.T) {
+	jwt := fixtureJWT(t, "https://issuer.example.com", "keybase-wot", "user-42")
+	confidence := confidenceForJWT(t, jwt)
+	confidence.OidcTokenHash = base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	err := VerifyOIDCVouchToken(confidence, jwt)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match the hash")
+}
+
+func TestVerifyOIDCVouchTokenRejectsClaimMismatch(t *testing.T) {
+	jwt := fixtureJWT(t, "https://issuer.example.com", "keybase-wot", "user-42")
+	confidence := confidenceForJWT(t, jwt)
+	confidence.OidcSubject = "someone-else"
+	err := VerifyOIDCVouchToken(confidence, jwt)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "subject")
+}
+
+func TestVerifyOIDCVouchTokenRejectsMalformedJWT(t *testing.T) {
+	confidence := keybase1.Confidence{}
+	err := VerifyOIDCVouchToken(confidence, "not-a-jwt")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "malformed JWT")
+}
+
+func TestOidcIssuerAllowed(t *testing.T) {
+	allowlist := []string{"https://issuer.example.com", "https://sso.corp.example"}
+	require.True(t, oidcIssuerAllowed(allowlist, "https://issuer.example.com"))
+	require.False(t, oidcIssuerAllowed(allowlist, "https://evil.example.com"))
+	require.False(t, oidcIssuerAllowed(nil, "https://issuer.example.com"))
+}
+
+func TestValidateDpopKeyThumbprint(t *testing.T) {
+	sum := sha256.Sum256([]byte("some-dpop-key"))
+	good := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	require.NoError(t, validateDpopKeyThumbprint(map[string]interface{}{}))
+	require.NoError(t, validateDpopKeyThumbprint(map[string]interface{}{"dpop_key_thumbprint": good}))
+
+	err := validateDpopKeyThumbprint(map[string]interface{}{"dpop_key_thumbprint": "not valid base64url!!"})
+	require.Error(t, err)
+
+	short := base64.RawURLEncoding.EncodeToString([]byte("too-short"))
+	err = validateDpopKeyThumbprint(map[string]interface{}{"dpop_key_thumbprint": short})
+	require.Error(t, err)
+}
+
+// rfc7638ExampleJWK and rfc7638ExampleThumbprint are the worked example from
+// RFC 7638 section 3.1, used here as a known-good test vector.
+const rfc7638ExampleJWK = `{"kty":"RSA",` +
+	`"n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",` +
+	`"e":"AQAB"}`
+const rfc7638ExampleThumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+
+func TestJWKThumbprintMatchesRFC7638Vector(t *testing.T) {
+	thumbprint, err := jwkThumbprint(rfc7638ExampleJWK)
+	require.NoError(t, err)
+	require.Equal(t, rfc7638ExampleThumbprint, thumbprint)
+}
+
+func TestJWKThumbprintUnsupportedKeyType(t *testing.T) {
+	_, err := jwkThumbprint(`{"kty":"oct","k":"secret"}`)
+	require.Error(t, err)
+}
+
+func TestVerifyOIDCVouchDPoPProofSucceedsOnMatch(t *testing.T) {
+	confidence := keybase1.Confidence{DpopKeyThumbprint: rfc7638ExampleThumbprint}
+	require.NoError(t, VerifyOIDCVouchDPoPProof(confidence, rfc7638ExampleJWK))
+}
+
+func TestVerifyOIDCVouchDPoPProofRejectsMismatch(t *testing.T) {
+	confidence := keybase1.Confidence{DpopKeyThumbprint: "not-the-right-thumbprint"}
+	err := VerifyOIDCVouchDPoPProof(confidence, rfc7638ExampleJWK)
+	require.Error(t, err)
+}
+
+func TestVerifyOIDCVouchDPoPProofNoOpWithoutThumbprint(t *testing.T) {
+	require.NoError(t, VerifyOIDCVouchDPoPProof(keybase1.Confidence{}, rfc7638ExampleJWK))
+}
+
+func TestValidateOIDCTokenHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("some-jwt"))
+	good := base64.StdEncoding.EncodeToString(sum[:])
+	digest, err := validateOIDCTokenHash(good)
+	require.NoError(t, err)
+	require.Len(t, digest, sha256.Size)
+
+	_, err = validateOIDCTokenHash("not-base64!!!")
+	require.Error(t, err)
+
+	_, err = validateOIDCTokenHash(base64.StdEncoding.EncodeToString([]byte("too-short")))
+	require.Error(t, err)
+}
@@ -0,0 +1,88 @@
+// This file is hand-maintained, not generated: OIDC-backed vouching doesn't
+// have an avdl/keybase1/wot.avdl source yet, so there's nothing for
+// avdl-compiler to regenerate this from. When that schema lands, this should
+// be replaced by the generated output rather than hand-edited further.
+
+package keybase1
+
+type UsernameVerificationType int
+
+const (
+	UsernameVerificationType_NONE       UsernameVerificationType = 0
+	UsernameVerificationType_VIDEO      UsernameVerificationType = 1
+	UsernameVerificationType_AUDIO      UsernameVerificationType = 2
+	UsernameVerificationType_PROOFS     UsernameVerificationType = 3
+	UsernameVerificationType_OTHER      UsernameVerificationType = 4
+	UsernameVerificationType_IN_PERSON  UsernameVerificationType = 5
+	UsernameVerificationType_OIDC_TOKEN UsernameVerificationType = 6
+)
+
+func (o UsernameVerificationType) DeepCopy() UsernameVerificationType { return o }
+
+var UsernameVerificationTypeMap = map[string]UsernameVerificationType{
+	"NONE":       0,
+	"VIDEO":      1,
+	"AUDIO":      2,
+	"PROOFS":     3,
+	"OTHER":      4,
+	"IN_PERSON":  5,
+	"OIDC_TOKEN": 6,
+}
+
+var UsernameVerificationTypeRevMap = map[UsernameVerificationType]string{
+	0: "NONE",
+	1: "VIDEO",
+	2: "AUDIO",
+	3: "PROOFS",
+	4: "OTHER",
+	5: "IN_PERSON",
+	6: "OIDC_TOKEN",
+}
+
+func (e UsernameVerificationType) String() string {
+	if v, ok := UsernameVerificationTypeRevMap[e]; ok {
+		return v
+	}
+	return ""
+}
+
+// Confidence is how a vouchee should weigh a pending web-of-trust vouch.
+// UsernameVerifiedVia and VouchedBy are set for every vouch; the Oidc* and
+// DpopKeyThumbprint fields are only present when UsernameVerifiedVia is
+// OIDC_TOKEN.
+type Confidence struct {
+	UsernameVerifiedVia UsernameVerificationType `codec:"usernameVerifiedVia" json:"username_verified_via"`
+	VouchedBy           []string                 `codec:"vouchedBy" json:"vouched_by"`
+	// OidcIssuer, OidcAudience and OidcSubject are the `iss`/`aud`/`sub`
+	// claims of the OIDC ID token the voucher saw, recorded so a relying
+	// party can independently confirm them against the raw token out of
+	// band (see VerifyOIDCVouchToken in libkb) without the token itself
+	// ever living on the sigchain.
+	OidcIssuer    string `codec:"oidcIssuer,omitempty" json:"oidc_issuer,omitempty"`
+	OidcAudience  string `codec:"oidcAudience,omitempty" json:"oidc_audience,omitempty"`
+	OidcSubject   string `codec:"oidcSubject,omitempty" json:"oidc_subject,omitempty"`
+	OidcTokenHash string `codec:"oidcTokenHash,omitempty" json:"oidc_token_hash,omitempty"`
+	// DpopKeyThumbprint is the optional RFC 7638 JWK thumbprint of the DPoP
+	// proof-of-possession key the voucher's client presented when it
+	// redeemed the OIDC token, for issuers that support DPoP.
+	DpopKeyThumbprint string `codec:"dpopKeyThumbprint,omitempty" json:"dpop_key_thumbprint,omitempty"`
+}
+
+func (o Confidence) DeepCopy() Confidence {
+	return Confidence{
+		UsernameVerifiedVia: o.UsernameVerifiedVia.DeepCopy(),
+		VouchedBy: (func(x []string) []string {
+			if x == nil {
+				return nil
+			}
+			ret := make([]string, len(x))
+			copy(ret, x)
+			return ret
+		})(o.VouchedBy),
+		OidcIssuer:        o.OidcIssuer,
+		OidcAudience:      o.OidcAudience,
+		OidcSubject:       o.OidcSubject,
+		OidcTokenHash:     o.OidcTokenHash,
+		DpopKeyThumbprint: o.DpopKeyThumbprint,
+	}
+}